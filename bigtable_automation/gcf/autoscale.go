@@ -0,0 +1,82 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/pkg/errors"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf/telemetry"
+)
+
+// Defaults for the btMinNodes/btMaxNodes/btScaleUpNodes env vars, used when
+// a deployment doesn't override them.
+const (
+	defaultBTMinNodes     = 3
+	defaultBTMaxNodes     = 3
+	defaultBTScaleUpNodes = 3
+)
+
+// btNodesEnv reads an integer node-count env var, falling back to def if
+// it's unset or unparsable.
+func btNodesEnv(ctx context.Context, name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		telemetry.WithSpan(ctx).Info("Invalid node count env var, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// scaleBTCluster sets cluster's node count to nodes, clamped to
+// [btMinNodes, btMaxNodes].
+func scaleBTCluster(ctx context.Context, projectID, instance, cluster string, nodes int) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.scaleBTCluster")
+	defer span.End()
+	logger := telemetry.WithSpan(ctx, "instance", instance, "cluster", cluster)
+
+	if min := btNodesEnv(ctx, "btMinNodes", defaultBTMinNodes); nodes < min {
+		nodes = min
+	}
+	if max := btNodesEnv(ctx, "btMaxNodes", defaultBTMaxNodes); nodes > max {
+		nodes = max
+	}
+	instanceAdminClient, err := bigtable.NewInstanceAdminClient(ctx, projectID)
+	if err != nil {
+		return recordErr(span, errors.Wrap(err, "Unable to create an instance admin client"))
+	}
+	logger.Info("Scaling cluster", "nodes", nodes)
+	if err := instanceAdminClient.UpdateCluster(ctx, instance, cluster, int32(nodes)); err != nil {
+		return recordErr(span, errors.WithMessagef(err, "Unable to scale cluster %s/%s to %d nodes", instance, cluster, nodes))
+	}
+	return nil
+}
+
+// handleBTScaleDown scales cluster back down to btMinNodes once a
+// completed.txt marker shows the cache-building dataflow job is done (the
+// scale-down step described in the package comment).
+func handleBTScaleDown(ctx context.Context, projectID, instance, cluster string) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.handleBTScaleDown")
+	defer span.End()
+	return recordErr(span, scaleBTCluster(ctx, projectID, instance, cluster, btNodesEnv(ctx, "btMinNodes", defaultBTMinNodes)))
+}
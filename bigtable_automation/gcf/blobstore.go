@@ -0,0 +1,118 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+)
+
+// Supported values for the storageBackend env var.
+const (
+	backendGCS    = "gcs"
+	backendS3     = "s3"
+	backendAzBlob = "azblob"
+)
+
+// BlobStore abstracts the object-store operations the import state machine
+// depends on, so the same controller logic can run against GCS, S3 or Azure
+// Blob Storage. Paths passed to a BlobStore are scheme-qualified, e.g.
+// "gs://bucket/object", "s3://bucket/object" or "azblob://container/object".
+type BlobStore interface {
+	// Exists reports whether the object at path exists.
+	Exists(ctx context.Context, path string) (bool, error)
+	// Write writes data to the object at path, creating it if necessary.
+	Write(ctx context.Context, path, data string) error
+}
+
+// NewBlobStore returns the BlobStore selected by the storageBackend env var,
+// defaulting to GCS for backward compatibility with existing deployments.
+func NewBlobStore(ctx context.Context) (BlobStore, error) {
+	switch backend := os.Getenv("storageBackend"); backend {
+	case "", backendGCS:
+		return &gcsBlobStore{}, nil
+	case backendS3:
+		return newS3BlobStore(ctx)
+	case backendAzBlob:
+		return newAzBlobStore(ctx)
+	default:
+		return nil, errors.Errorf("Unsupported storageBackend: %s", backend)
+	}
+}
+
+// schemeForBackend returns the scheme prefix (e.g. "gs://") matching the
+// storageBackend env var, so callers that build scheme-qualified paths
+// (e.g. for ParseImportPath) agree with the backend NewBlobStore picked.
+func schemeForBackend() (string, error) {
+	switch backend := os.Getenv("storageBackend"); backend {
+	case "", backendGCS:
+		return gcsScheme, nil
+	case backendS3:
+		return s3Scheme, nil
+	case backendAzBlob:
+		return azblobScheme, nil
+	default:
+		return "", errors.Errorf("Unsupported storageBackend: %s", backend)
+	}
+}
+
+// gcsBlobStore is the BlobStore backed by Google Cloud Storage.
+type gcsBlobStore struct{}
+
+func (s *gcsBlobStore) Exists(ctx context.Context, path string) (bool, error) {
+	bucket, object, err := parseGCSPath(path)
+	if err != nil {
+		return false, err
+	}
+	gcsClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to create gcsClient")
+	}
+	_, err = gcsClient.Bucket(bucket).Object(object).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	return true, err
+}
+
+func (s *gcsBlobStore) Write(ctx context.Context, path, data string) error {
+	bucket, object, err := parseGCSPath(path)
+	if err != nil {
+		return err
+	}
+	gcsClient, err := storage.NewClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create gcsClient")
+	}
+	w := gcsClient.Bucket(bucket).Object(object).NewWriter(ctx)
+	defer w.Close()
+	_, err = fmt.Fprint(w, data)
+	return errors.WithMessagef(err, "Failed to write data to %s/%s", bucket, object)
+}
+
+// parseGCSPath returns the bucket and object from a path of the form
+// gs://<bucket>/<object>.
+func parseGCSPath(path string) (string, string, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "gs:" || parts[1] != "" || len(parts) < 3 {
+		return "", "", errors.Errorf("Unexpected path: %s", path)
+	}
+	return parts[2], strings.Join(parts[3:], "/"), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/pkg/errors"
+)
+
+// azBlobStore is the BlobStore backed by Azure Blob Storage.
+type azBlobStore struct {
+	client *azblob.Client
+}
+
+func newAzBlobStore(ctx context.Context) (*azBlobStore, error) {
+	accountURL := os.Getenv("azAccountURL")
+	if accountURL == "" {
+		return nil, errors.New("azAccountURL is not set in environment")
+	}
+	cred, err := azblob.NewSharedKeyCredential(os.Getenv("azAccountName"), os.Getenv("azAccountKey"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create Azure credential")
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(accountURL, cred, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create Azure blob client")
+	}
+	return &azBlobStore{client: client}, nil
+}
+
+func (s *azBlobStore) Exists(ctx context.Context, path string) (bool, error) {
+	container, blob, err := parseAzBlobPath(path)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.client.ServiceClient().NewContainerClient(container).NewBlobClient(blob).GetProperties(ctx, nil); err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, errors.WithMessagef(err, "Failed to check existence of %s/%s", container, blob)
+	}
+	return true, nil
+}
+
+func (s *azBlobStore) Write(ctx context.Context, path, data string) error {
+	container, blob, err := parseAzBlobPath(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.UploadBuffer(ctx, container, blob, bytes.NewBufferString(data).Bytes(), nil)
+	return errors.WithMessagef(err, "Failed to write data to %s/%s", container, blob)
+}
+
+// parseAzBlobPath returns the container and blob name from a path of the
+// form azblob://<container>/<blob>.
+func parseAzBlobPath(path string) (string, string, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "azblob:" || parts[1] != "" || len(parts) < 3 {
+		return "", "", errors.Errorf("Unexpected path: %s", path)
+	}
+	return parts[2], strings.Join(parts[3:], "/"), nil
+}
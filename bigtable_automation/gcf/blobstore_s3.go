@@ -0,0 +1,86 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/pkg/errors"
+)
+
+// s3BlobStore is the BlobStore backed by AWS S3.
+type s3BlobStore struct {
+	client *s3.Client
+}
+
+func newS3BlobStore(ctx context.Context) (*s3BlobStore, error) {
+	region := os.Getenv("s3Region")
+	if region == "" {
+		return nil, errors.New("s3Region is not set in environment")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to load AWS config")
+	}
+	return &s3BlobStore{client: s3.NewFromConfig(cfg)}, nil
+}
+
+func (s *s3BlobStore) Exists(ctx context.Context, path string) (bool, error) {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+		// HeadObject doesn't return a modeled "not found" error (S3 can't send
+		// an XML error body for a HEAD request); it surfaces as a generic
+		// *smithyhttp.ResponseError with a 404 status instead.
+		var respErr *smithyhttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, errors.WithMessagef(err, "Failed to check existence of %s/%s", bucket, key)
+	}
+	return true, nil
+}
+
+func (s *s3BlobStore) Write(ctx context.Context, path, data string) error {
+	bucket, key, err := parseS3Path(path)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(data),
+	})
+	return errors.WithMessagef(err, "Failed to write data to %s/%s", bucket, key)
+}
+
+// parseS3Path returns the bucket and key from a path of the form
+// s3://<bucket>/<key>.
+func parseS3Path(path string) (string, string, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "s3:" || parts[1] != "" || len(parts) < 3 {
+		return "", "", errors.Errorf("Unexpected path: %s", path)
+	}
+	return parts[2], strings.Join(parts[3:], "/"), nil
+}
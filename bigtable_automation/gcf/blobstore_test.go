@@ -0,0 +1,134 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import "testing"
+
+func TestParseGCSPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantBucket string
+		wantObject string
+		wantErr    bool
+	}{
+		{name: "simple", path: "gs://bucket/object", wantBucket: "bucket", wantObject: "object"},
+		{name: "nested object", path: "gs://bucket/a/b/c", wantBucket: "bucket", wantObject: "a/b/c"},
+		{name: "missing scheme", path: "bucket/object", wantErr: true},
+		{name: "wrong scheme", path: "s3://bucket/object", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket, object, err := parseGCSPath(tc.path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseGCSPath(%q) error = %v, wantErr %v", tc.path, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if bucket != tc.wantBucket || object != tc.wantObject {
+				t.Errorf("parseGCSPath(%q) = (%q, %q), want (%q, %q)", tc.path, bucket, object, tc.wantBucket, tc.wantObject)
+			}
+		})
+	}
+}
+
+func TestParseS3Path(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{name: "simple", path: "s3://bucket/key", wantBucket: "bucket", wantKey: "key"},
+		{name: "nested key", path: "s3://bucket/a/b/c", wantBucket: "bucket", wantKey: "a/b/c"},
+		{name: "missing scheme", path: "bucket/key", wantErr: true},
+		{name: "wrong scheme", path: "gs://bucket/key", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket, key, err := parseS3Path(tc.path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseS3Path(%q) error = %v, wantErr %v", tc.path, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if bucket != tc.wantBucket || key != tc.wantKey {
+				t.Errorf("parseS3Path(%q) = (%q, %q), want (%q, %q)", tc.path, bucket, key, tc.wantBucket, tc.wantKey)
+			}
+		})
+	}
+}
+
+func TestParseAzBlobPath(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		wantContainer string
+		wantBlob      string
+		wantErr       bool
+	}{
+		{name: "simple", path: "azblob://container/blob", wantContainer: "container", wantBlob: "blob"},
+		{name: "nested blob", path: "azblob://container/a/b/c", wantContainer: "container", wantBlob: "a/b/c"},
+		{name: "missing scheme", path: "container/blob", wantErr: true},
+		{name: "wrong scheme", path: "gs://container/blob", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			container, blob, err := parseAzBlobPath(tc.path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseAzBlobPath(%q) error = %v, wantErr %v", tc.path, err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if container != tc.wantContainer || blob != tc.wantBlob {
+				t.Errorf("parseAzBlobPath(%q) = (%q, %q), want (%q, %q)", tc.path, container, blob, tc.wantContainer, tc.wantBlob)
+			}
+		})
+	}
+}
+
+func TestSchemeForBackend(t *testing.T) {
+	tests := []struct {
+		name       string
+		backend    string
+		wantScheme string
+		wantErr    bool
+	}{
+		{name: "default", backend: "", wantScheme: gcsScheme},
+		{name: "gcs", backend: backendGCS, wantScheme: gcsScheme},
+		{name: "s3", backend: backendS3, wantScheme: s3Scheme},
+		{name: "azblob", backend: backendAzBlob, wantScheme: azblobScheme},
+		{name: "unsupported", backend: "ftp", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("storageBackend", tc.backend)
+			scheme, err := schemeForBackend()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("schemeForBackend() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if scheme != tc.wantScheme {
+				t.Errorf("schemeForBackend() = %q, want %q", scheme, tc.wantScheme)
+			}
+		})
+	}
+}
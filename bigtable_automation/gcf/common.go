@@ -25,101 +25,88 @@
 // which targets on production imports and private imports. The folder structure
 // are different for the two scenarios.
 // The environment variables for deployments are stored in (prod|private)/*.yaml
+//
+// The resource bucket, control folder and cache outputs can live on GCS, S3
+// or Azure Blob Storage; the storageBackend env var selects which one (see
+// BlobStore).
 package gcf
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/bigtable"
-	"cloud.google.com/go/storage"
+	"github.com/cenkalti/backoff/v4"
 	"github.com/pkg/errors"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf/telemetry"
 )
 
 const (
-	createTableRetries = 3
-	columnFamily       = "csv"
-	gsScheme           = "gs://"
-	csvFileExtension   = ".csv"
+	columnFamily     = "csv"
+	csvFileExtension = ".csv"
+
+	// Marker file names in a given table's control directory. handleBTCache
+	// acts on initFile and completedFile; the import's progress itself is
+	// kept by ImportStateMachine. launchedFile is neither written nor read.
+	initFile      = "init.txt"
+	launchedFile  = "launched.txt"
+	completedFile = "completed.txt"
 )
 
-// GCSEvent is the payload of a GCS event.
-type GCSEvent struct {
-	Name   string `json:"name"` // File name in the control folder
-	Bucket string `json:"bucket"`
-}
-
-// parsePath returns the GCS bucket and object from path in the form of gs://<bucket>/<object>
-func parsePath(path string) (string, string, error) {
-	parts := strings.Split(path, "/")
-	if parts[0] != "gs:" || parts[1] != "" || len(parts) < 3 {
-		return "", "", errors.Errorf("Unexpected path: %s", path)
-	}
-	return parts[2], strings.Join(parts[3:], "/"), nil
-}
-
-func doesObjectExist(ctx context.Context, path string) (bool, error) {
-	bucket, object, err := parsePath(path)
-	if err != nil {
-		return false, err
-	}
-	gcsClient, err := storage.NewClient(ctx)
-	if err != nil {
-		return false, errors.Wrap(err, "Failed to create gcsClient")
-	}
-	_, err = gcsClient.Bucket(bucket).Object(object).Attrs(ctx)
-	if err == storage.ErrObjectNotExist {
-		return false, nil
-	}
-	return true, nil
+// joinURL joins a scheme-qualified base path (e.g. "gs://bucket/path") with
+// additional path segments using "/", since path/filepath.Join cleans the
+// result and so collapses the "//" after the scheme.
+func joinURL(base string, elem ...string) string {
+	return strings.Join(append([]string{strings.TrimSuffix(base, "/")}, elem...), "/")
 }
 
-func writeToGCS(ctx context.Context, path, data string) error {
-	bucket, object, err := parsePath(path)
-	if err != nil {
-		return err
+// setupBT scales btCluster up, then creates tableID and its columnFamily if
+// they don't already exist, retrying with exponential backoff and jitter
+// (instead of a fixed sleep) so a redelivered trigger doesn't fail outright
+// just because a previous attempt got partway through.
+func setupBT(ctx context.Context, btProjectID, btInstance, btCluster, tableID string) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.setupBT")
+	defer span.End()
+	logger := telemetry.WithSpan(ctx, "tableID", tableID)
+
+	if err := scaleBTCluster(ctx, btProjectID, btInstance, btCluster, btNodesEnv(ctx, "btScaleUpNodes", defaultBTScaleUpNodes)); err != nil {
+		return recordErr(span, err)
 	}
-	gcsClient, err := storage.NewClient(ctx)
-	if err != nil {
-		return errors.Wrap(err, "Failed to create gcsClient")
-	}
-	w := gcsClient.Bucket(bucket).Object(object).NewWriter(ctx)
-	defer w.Close()
-	_, err = fmt.Fprint(w, data)
-	return errors.WithMessagef(err, "Failed to write data to %s/%s", bucket, object)
-}
 
-func setupBT(ctx context.Context, btProjectID, btInstance, tableID string) error {
 	adminClient, err := bigtable.NewAdminClient(ctx, btProjectID, btInstance)
 	if err != nil {
-		return errors.Wrap(err, "Unable to create a table admin client")
+		return recordErr(span, errors.Wrap(err, "Unable to create a table admin client"))
 	}
-	// Create table. We retry 3 times in 1 minute intervals.
 	dctx, cancel := context.WithDeadline(ctx, time.Now().Add(10*time.Minute))
 	defer cancel()
-	var ok bool
-	for ii := 0; ii < createTableRetries; ii++ {
-		log.Printf("Creating new bigtable table (%d): %s/%s", ii, btInstance, tableID)
-		err = adminClient.CreateTable(dctx, tableID)
+
+	attempt := 0
+	bo := backoff.WithContext(backoff.NewExponentialBackOff(), dctx)
+	err = backoff.Retry(func() error {
+		attempt++
+		info, err := adminClient.TableInfo(dctx, tableID)
 		if err != nil {
-			log.Printf("Error creating table %s, retry...", err)
-		} else {
-			ok = true
-			break
+			logger.Info("Creating new bigtable table", "instance", btInstance, "attempt", attempt)
+			if err := adminClient.CreateTable(dctx, tableID); err != nil {
+				logger.Info("Error creating table, retrying", "attempt", attempt, "error", err)
+				return err
+			}
+			logger.Info("Creating column family", "family", columnFamily, "instance", btInstance)
+			return adminClient.CreateColumnFamily(dctx, tableID, columnFamily)
 		}
-		time.Sleep(1 * time.Minute)
-	}
-	if !ok {
-		return errors.Errorf("Unable to create table: %s, got error: %v", tableID, err)
-	}
-	// Create table columnFamily.
-	log.Printf("Creating column family %s in table %s/%s", columnFamily, btInstance, tableID)
-	if err := adminClient.CreateColumnFamily(dctx, tableID, columnFamily); err != nil {
-		return errors.WithMessagef(err, "Unable to create column family: csv for table: %s, got error: %v", tableID)
+		for _, fam := range info.FamilyInfos {
+			if fam.Name == columnFamily {
+				// Table and column family already exist from a previous attempt.
+				return nil
+			}
+		}
+		logger.Info("Creating column family", "family", columnFamily, "instance", btInstance)
+		return adminClient.CreateColumnFamily(dctx, tableID, columnFamily)
+	}, bo)
+	if err != nil {
+		return recordErr(span, errors.WithMessagef(err, "Unable to create table: %s", tableID))
 	}
 	return nil
 }
@@ -131,43 +118,3 @@ func deleteBTTable(ctx context.Context, projectID, instance, table string) error
 	}
 	return adminClient.DeleteTable(ctx, table)
 }
-
-// ImportGCSPath holds GCS path info for custom dc v1.
-// import name inside the pubsub message to trigger controller must match
-// the import name in config.textproto (specified by customManifestPath).
-// Ideally we should read import name from config.text proto directly, but
-// since manifest protos are not public yet, we will use the folder name instead.
-//
-// Custom DC resource bucket MUST follow the following directory structure.
-// <resource bucket name>/<some path>/<import name>/config/config.textproto
-// <resource bucket name>/<some path>/<import name>/tmcf_csv/*.csv
-// <resource bucket name>/<some path>/<import name>/tmcf_csv/*.tmcf
-// <resource bucket name>/<some path>/<import name>/<other folders like control, cache>
-type ImportGCSPath struct {
-	// GCS base path for a particular import.
-	// This should be under the resource bucket.
-	importName string
-}
-
-func (p ImportGCSPath) ImportName() string {
-	return filepath.Base(p.importName)
-}
-
-// ConfigPath must be <base>/config/config.textproto
-func (p ImportGCSPath) ConfigPath() string {
-	return filepath.Join(p.importName, "config", "config.textproto")
-}
-
-// DataDirectory is the expected location for tmcf and csvs.
-// It is expected that csv files are dropped off in this directory.
-func (p ImportGCSPath) DataDirectory() string {
-	return filepath.Join(p.importName, "tmcf_csv")
-}
-
-func (p ImportGCSPath) CacheDirectory() string {
-	return filepath.Join(p.importName, "cache")
-}
-
-func (p ImportGCSPath) ControlDirectory() string {
-	return filepath.Join(p.importName, "control")
-}
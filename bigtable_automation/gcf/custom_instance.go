@@ -16,126 +16,193 @@ package gcf
 
 import (
 	"context"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf/telemetry"
 )
 
-// blobName is assumed to be under the correct path in "control".
-func handleBTCache((ctx context.Context, blobName string) error {
+func init() {
+	// Undo setupBT if launchDataflowJob fails later in the same invocation.
+	// A function killed between the two runs no rollback: the table stays and
+	// the import stays at StateTableCreated, and a redelivered init trigger
+	// reuses the table (see setupBT) before launching again.
+	RegisterCompensation(StateTableCreated, func(ctx context.Context, importName string, rec ImportRecord) error {
+		return deleteBTTable(ctx, os.Getenv("projectID"), os.Getenv("instance"), importName)
+	})
+}
+
+// recordErr records err on span (if non-nil) and returns it unchanged, so
+// call sites can `return recordErr(span, err)`.
+func recordErr(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// blobName is assumed to be under the correct path in "control". The
+// pipeline's progress is tracked by an ImportStateMachine (keyed by table
+// ID) rather than by the presence of marker blobs, so a redelivered trigger
+// resumes instead of racing or duplicating work.
+func handleBTCache(ctx context.Context, blobName string) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.handleBTCache")
+	defer span.End()
+	logger := telemetry.WithSpan(ctx)
+
 	projectID := os.Getenv("projectID")
 	bucket := os.Getenv("bucket")
 	instance := os.Getenv("instance")
 	cluster := os.Getenv("cluster")
 	dataflowTemplate := os.Getenv("dataflowTemplate")
 	if projectID == "" {
-		return errors.New("projectID is not set in environment")
+		return recordErr(span, errors.New("projectID is not set in environment"))
 	}
 	if instance == "" {
-		return errors.New("instance is not set in environment")
+		return recordErr(span, errors.New("instance is not set in environment"))
 	}
 	if cluster == "" {
-		return errors.New("cluster is not set in environment")
+		return recordErr(span, errors.New("cluster is not set in environment"))
 	}
 	if dataflowTemplate == "" {
-		return errors.New("dataflowTemplate is not set in environment")
+		return recordErr(span, errors.New("dataflowTemplate is not set in environment"))
 	}
 	if bucket == "" {
-		return errors.New("bucket is not set in environment")
+		return recordErr(span, errors.New("bucket is not set in environment"))
 	}
 
-	parts := strings.Split(blobName, "/")
+	if !strings.HasSuffix(blobName, initFile) && !strings.HasSuffix(blobName, completedFile) {
+		return nil
+	}
 
+	parts := strings.Split(blobName, "/")
 	idxTable := len(parts) - 2
 	tableID := parts[idxTable]
-	rootFolder := "gs://" + bucket + "/" + strings.Join(parts[0:idxControlOrProcess], "/")
+	idxControlOrProcess := idxTable - 1
+
+	scheme, err := schemeForBackend()
+	if err != nil {
+		return recordErr(span, err)
+	}
+	importPath, err := ParseImportPath(joinURL(scheme+bucket, parts[0:idxControlOrProcess]...))
+	if err != nil {
+		return recordErr(span, err)
+	}
+
+	span.SetAttributes(attribute.String("importName", tableID), attribute.String("tableID", tableID))
+	logger = logger.With("importName", tableID, "tableID", tableID)
+
+	sm, err := Resume(ctx, tableID)
+	if err != nil {
+		return recordErr(span, err)
+	}
+	span.SetAttributes(attribute.String("state", string(sm.State())))
 
 	if strings.HasSuffix(blobName, initFile) {
-		log.Printf("[%s] State Init", blobName)
-		// Called when the state-machine is at Init. Logic below moves it to Launched state.
-		launchedPath := joinURL(rootFolder, "control", tableID, launchedFile)
-		exist, err := doesObjectExist(ctx, launchedPath)
-		if err != nil {
-			return errors.WithMessagef(err, "Failed to check %s", launchedFile)
+		switch sm.State() {
+		case StateDataflowLaunched, StateCacheBuilt:
+			logger.Info("Import already past Init, ignoring redelivered trigger", "state", sm.State())
+			return nil
 		}
-		if exist {
-			return errors.WithMessagef(err, "Cache was already built for %s", tableID)
+		if store, err := NewBlobStore(ctx); err != nil {
+			logger.Error("Failed to create blob store for config check", "error", err)
+		} else if exists, err := store.Exists(ctx, importPath.ConfigPath()); err != nil {
+			logger.Error("Failed to check for config", "path", importPath.ConfigPath(), "error", err)
+		} else if !exists {
+			logger.Error("config.textproto not found at expected path", "path", importPath.ConfigPath())
 		}
-		if err := setupBT(ctx, projectID, instance, tableID); err != nil {
-			return err
+		if err := setupBT(ctx, projectID, instance, cluster, tableID); err != nil {
+			_ = sm.Transition(ctx, StateFailed, err)
+			return recordErr(span, err)
 		}
-		dataPath := joinURL(rootFolder, "cache")
-		controlPath := joinURL(rootFolder, "control")
-		err = launchDataflowJob(ctx, projectID, instance, tableID, dataPath, controlPath, dataflowTemplate)
-		if err != nil {
-			if errDeleteBT := deleteBTTable(ctx, projectID, instance, tableID); errDeleteBT != nil {
-				log.Printf("Failed to delete BT table on failed Dataflow launch: %v", errDeleteBT)
-			}
-			return err
+		if err := sm.Transition(ctx, StateTableCreated, nil); err != nil {
+			return recordErr(span, err)
 		}
-		// Save the fact that we've launched the dataflow job.
-		err = writeToGCS(ctx, launchedPath, "")
-		if err != nil {
-			if errDeleteBT := deleteBTTable(ctx, projectID, instance, tableID); errDeleteBT != nil {
-				log.Printf("Failed to delete BT table on failed GCS write: %v", errDeleteBT)
+		dataPath := importPath.CacheDirectory()
+		controlPath := importPath.ControlDirectory()
+		if err := launchDataflowJob(ctx, projectID, instance, tableID, dataPath, controlPath, dataflowTemplate); err != nil {
+			if rbErr := sm.Rollback(ctx, StateTableCreated); rbErr != nil {
+				logger.Error("Rollback of StateTableCreated failed", "error", rbErr)
 			}
-			return err
+			_ = sm.Transition(ctx, StateFailed, err)
+			return recordErr(span, err)
+		}
+		if err := sm.Transition(ctx, StateDataflowLaunched, nil); err != nil {
+			return recordErr(span, err)
 		}
-		log.Printf("[%s] State Launched", blobName)
+		logger.Info("State transition complete", "state", StateDataflowLaunched)
 	} else if strings.HasSuffix(blobName, completedFile) {
+		if err := sm.Transition(ctx, StateCacheBuilt, nil); err != nil {
+			return recordErr(span, err)
+		}
+		if err := handleBTScaleDown(ctx, projectID, instance, cluster); err != nil {
+			logger.Error("Failed to scale down cluster", "cluster", cluster, "error", err)
+		}
 		// TODO: else, notify Mixer to load the BT table.
-		log.Printf("[%s] Completed work", blobName)
+		logger.Info("Completed work")
 	}
 	return nil
 }
 
 func handleControllerTrigger(ctx context.Context, bucket, blobPath string) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.handleControllerTrigger")
+	defer span.End()
+
 	controllerTriggerTopic := os.Getenv("controllerTriggerTopic")
 	if controllerTriggerTopic == "" {
-		return errors.New("controllerTriggerTopic is not set in environment")
+		return recordErr(span, errors.New("controllerTriggerTopic is not set in environment"))
 	}
 
 	bigstoreCSVPath := filepath.Join("/bigstore", bucket, blobPath)
-	log.Printf("Using PubSub topic: %s", controllerTriggerTopic)
+	telemetry.WithSpan(ctx).Info("Publishing controller trigger", "topic", controllerTriggerTopic)
 	pcfg := PublishConfig{TopicName: controllerTriggerTopic}
-	return TriggerController(ctx, pcfg, bigstoreCSVPath)
+	return recordErr(span, TriggerController(ctx, pcfg, bigstoreCSVPath))
 }
 
 // TODO(alex): refactor path -> event handler logic.
-func customInternal(ctx context.Context, e GCSEvent) error {
-
+func customInternal(ctx context.Context, evt StorageEvent) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.customInternal")
+	defer span.End()
+	logger := telemetry.WithSpan(ctx)
 
 	// Get table ID.
-	// e.Name should is like "**/<user>/<import>/control/<table_id>/launched.txt"
-	parts := strings.Split(e.Name, "/")
-	idxControlOrProcess := len(parts) - 3
+	// evt.Name should be like "**/<user>/<import>/control/<table_id>/launched.txt"
+	parts := strings.Split(evt.Name, "/")
 	if len(parts) < 3 {
-		log.Printf("Expected 3+ '/'-separated parts, got %s", e.Name)
-		log.Println("Ignoring as irrelevant file")
+		logger.Info("Ignoring irrelevant file", "name", evt.Name, "reason", "expected 3+ '/'-separated parts")
 		return nil
 	}
+	idxControlOrProcess := len(parts) - 3
 
 	if parts[idxControlOrProcess] != "control" && parts[idxControlOrProcess] != "process" {
-		log.Printf("Ignore irrelevant trigger from file %s", e.Name)
+		logger.Info("Ignoring irrelevant trigger", "name", evt.Name)
 		return nil
 	}
 
 	if parts[idxControlOrProcess] == "control" {
-		return handleBTCache(ctx, e.Name)
-	} else if parts[idxControlOrProcess] == "process" && strings.HasSuffix(e.Name, controllerTriggerFile) {
-		return handleControllerTrigger(ctx, bucket, e.Name)
-	} else {
-		log.Printf("Ignore irrelevant trigger from file %s", e.Name)
-		return nil
+		return recordErr(span, handleBTCache(ctx, evt.Name))
+	} else if parts[idxControlOrProcess] == "process" && strings.HasSuffix(evt.Name, controllerTriggerFile) {
+		return recordErr(span, handleControllerTrigger(ctx, evt.Bucket, evt.Name))
 	}
+	logger.Info("Ignoring irrelevant trigger", "name", evt.Name)
+	return nil
 }
 
 // CustomBTImportController consumes a GCS event and runs an import state machine.
 func CustomBTImportController(ctx context.Context, e GCSEvent) error {
-	err := customInternal(ctx, e)
+	if err := telemetry.Init(ctx, os.Getenv("projectID")); err != nil {
+		telemetry.Logger().Error("Failed to initialize telemetry", "error", err)
+	}
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.CustomBTImportController")
+	defer span.End()
+	err := recordErr(span, customInternal(ctx, e.ToStorageEvent()))
 	if err != nil {
 		// Panic gets reported to Cloud Logging Error Reporting that we can then
 		// alert on
@@ -144,3 +211,39 @@ func CustomBTImportController(ctx context.Context, e GCSEvent) error {
 	}
 	return nil
 }
+
+// CustomS3ImportController consumes an S3 "ObjectCreated" notification and
+// runs the same import state machine as CustomBTImportController.
+func CustomS3ImportController(ctx context.Context, e S3Event) error {
+	if err := telemetry.Init(ctx, os.Getenv("projectID")); err != nil {
+		telemetry.Logger().Error("Failed to initialize telemetry", "error", err)
+	}
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.CustomS3ImportController")
+	defer span.End()
+	evt, err := e.ToStorageEvent()
+	if err == nil {
+		err = customInternal(ctx, evt)
+	}
+	if err = recordErr(span, err); err != nil {
+		panic(errors.Wrap(err, "panic"))
+	}
+	return nil
+}
+
+// CustomAzBlobImportController consumes an Azure Event Grid "BlobCreated"
+// event and runs the same import state machine as CustomBTImportController.
+func CustomAzBlobImportController(ctx context.Context, e EventGridEvent) error {
+	if err := telemetry.Init(ctx, os.Getenv("projectID")); err != nil {
+		telemetry.Logger().Error("Failed to initialize telemetry", "error", err)
+	}
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.CustomAzBlobImportController")
+	defer span.End()
+	evt, err := e.ToStorageEvent()
+	if err == nil {
+		err = customInternal(ctx, evt)
+	}
+	if err = recordErr(span, err); err != nil {
+		panic(errors.Wrap(err, "panic"))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf"
+	gcftesting "github.com/datacommonsorg/tools/bigtable_automation/gcf/testing"
+)
+
+func TestCustomBTImportController_LaunchSucceeds(t *testing.T) {
+	env := gcftesting.NewFakeEnv(t)
+	launcher := &gcftesting.FakeDataflowLauncher{}
+	gcftesting.UseFakeDataflowLauncher(t, launcher)
+
+	const tableID = "some_import"
+	env.SeedControlFile(t, tableID, "init.txt")
+
+	err := gcf.CustomBTImportController(context.Background(), gcf.GCSEvent{
+		Bucket: env.Bucket,
+		Name:   "some/path/" + tableID + "/control/" + tableID + "/init.txt",
+	})
+	if err != nil {
+		t.Fatalf("CustomBTImportController() = %v, want nil", err)
+	}
+
+	env.AssertTableExists(t, tableID)
+	env.AssertColumnFamilyExists(t, tableID, "csv")
+	env.AssertImportState(t, tableID, gcf.StateDataflowLaunched)
+	if len(launcher.Calls) != 1 {
+		t.Fatalf("Launch called %d times, want 1", len(launcher.Calls))
+	}
+	wantDataPath := "gs://" + env.Bucket + "/some/path/" + tableID + "/cache"
+	wantControlPath := "gs://" + env.Bucket + "/some/path/" + tableID + "/control"
+	if got := launcher.Calls[0].DataPath; got != wantDataPath {
+		t.Errorf("Launch DataPath = %q, want %q", got, wantDataPath)
+	}
+	if got := launcher.Calls[0].ControlPath; got != wantControlPath {
+		t.Errorf("Launch ControlPath = %q, want %q", got, wantControlPath)
+	}
+}
+
+func TestCustomBTImportController_LaunchFailsRollsBackTable(t *testing.T) {
+	env := gcftesting.NewFakeEnv(t)
+	launcher := &gcftesting.FakeDataflowLauncher{Err: errors.New("dataflow unavailable")}
+	gcftesting.UseFakeDataflowLauncher(t, launcher)
+
+	const tableID = "some_import"
+	env.SeedControlFile(t, tableID, "init.txt")
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatalf("CustomBTImportController() did not panic on Dataflow launch failure")
+		}
+		env.AssertTableNotExists(t, tableID)
+		env.AssertImportState(t, tableID, gcf.StateFailed)
+	}()
+	gcf.CustomBTImportController(context.Background(), gcf.GCSEvent{
+		Bucket: env.Bucket,
+		Name:   "some/path/" + tableID + "/control/" + tableID + "/init.txt",
+	})
+}
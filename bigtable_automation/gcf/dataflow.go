@@ -0,0 +1,81 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"context"
+	"fmt"
+
+	dataflow "cloud.google.com/go/dataflow/apiv1beta3"
+	dataflowpb "cloud.google.com/go/dataflow/apiv1beta3/dataflowpb"
+	"github.com/pkg/errors"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf/telemetry"
+)
+
+// DataflowLauncher launches the Dataflow job that builds the BT cache. It is
+// the seam gcf/testing mocks to exercise handleBTCache's rollback path
+// (deleting the BT table when launch fails) without touching real Dataflow.
+type DataflowLauncher interface {
+	Launch(ctx context.Context, projectID, instance, tableID, dataPath, controlPath, template string) error
+}
+
+// dataflowLauncher is the DataflowLauncher launchDataflowJob calls through.
+var dataflowLauncher DataflowLauncher = cloudDataflowLauncher{}
+
+// SetDataflowLauncher overrides the DataflowLauncher used by
+// launchDataflowJob and returns a func that restores the previous one. Used
+// by gcf/testing fakes.
+func SetDataflowLauncher(l DataflowLauncher) (restore func()) {
+	prev := dataflowLauncher
+	dataflowLauncher = l
+	return func() { dataflowLauncher = prev }
+}
+
+func launchDataflowJob(ctx context.Context, projectID, instance, tableID, dataPath, controlPath, template string) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "gcf.launchDataflowJob")
+	defer span.End()
+	telemetry.WithSpan(ctx, "tableID", tableID).Info("Launching dataflow job", "template", template)
+	return recordErr(span, dataflowLauncher.Launch(ctx, projectID, instance, tableID, dataPath, controlPath, template))
+}
+
+// cloudDataflowLauncher is the production DataflowLauncher. It launches
+// template (a GCS path to a classic Dataflow template) with dataPath and
+// controlPath as the input/output locations for tableID.
+type cloudDataflowLauncher struct{}
+
+func (cloudDataflowLauncher) Launch(ctx context.Context, projectID, instance, tableID, dataPath, controlPath, template string) error {
+	client, err := dataflow.NewTemplatesClient(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create dataflow templates client")
+	}
+	defer client.Close()
+
+	req := &dataflowpb.LaunchTemplateRequest{
+		ProjectId: projectID,
+		GcsPath:   template,
+		LaunchParameters: &dataflowpb.LaunchTemplateParameters{
+			JobName: fmt.Sprintf("bt-cache-%s", tableID),
+			Parameters: map[string]string{
+				"bigtableInstanceId": instance,
+				"bigtableTableId":    tableID,
+				"inputDirectory":     dataPath,
+				"controlDirectory":   controlPath,
+			},
+		},
+	}
+	_, err = client.LaunchTemplate(ctx, req)
+	return errors.WithMessagef(err, "Failed to launch dataflow template for table %s", tableID)
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Scheme prefixes recognized by ParseImportPath, one per supported
+// storageBackend.
+const (
+	gcsScheme    = "gs://"
+	s3Scheme     = "s3://"
+	azblobScheme = "azblob://"
+)
+
+// ImportPath holds the resource-bucket path info for custom dc v1, scoped to
+// whichever storage backend (GCS, S3 or Azure Blob Storage) hosts it.
+// import name inside the pubsub message to trigger controller must match
+// the import name in config.textproto (specified by customManifestPath).
+// Ideally we should read import name from config.text proto directly, but
+// since manifest protos are not public yet, we will use the folder name instead.
+//
+// Custom DC resource bucket MUST follow the following directory structure.
+// <scheme>://<resource bucket name>/<some path>/<import name>/config/config.textproto
+// <scheme>://<resource bucket name>/<some path>/<import name>/tmcf_csv/*.csv
+// <scheme>://<resource bucket name>/<some path>/<import name>/tmcf_csv/*.tmcf
+// <scheme>://<resource bucket name>/<some path>/<import name>/<other folders like control, cache>
+type ImportPath struct {
+	// Scheme-qualified base path for a particular import, e.g.
+	// "gs://bucket/some/path/<import name>".
+	importName string
+}
+
+// ParseImportPath validates that path carries one of the supported scheme
+// prefixes (gs://, s3://, azblob://) and returns it as an ImportPath.
+func ParseImportPath(path string) (ImportPath, error) {
+	switch {
+	case strings.HasPrefix(path, gcsScheme), strings.HasPrefix(path, s3Scheme), strings.HasPrefix(path, azblobScheme):
+		return ImportPath{importName: path}, nil
+	default:
+		return ImportPath{}, errors.Errorf("Unexpected import path: %s", path)
+	}
+}
+
+func (p ImportPath) ImportName() string {
+	return filepath.Base(p.importName)
+}
+
+// ConfigPath must be <base>/config/config.textproto
+func (p ImportPath) ConfigPath() string {
+	return joinURL(p.importName, "config", "config.textproto")
+}
+
+// DataDirectory is the expected location for tmcf and csvs.
+// It is expected that csv files are dropped off in this directory.
+func (p ImportPath) DataDirectory() string {
+	return joinURL(p.importName, "tmcf_csv")
+}
+
+func (p ImportPath) CacheDirectory() string {
+	return joinURL(p.importName, "cache")
+}
+
+func (p ImportPath) ControlDirectory() string {
+	return joinURL(p.importName, "control")
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import "testing"
+
+func TestParseImportPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "gcs", path: "gs://bucket/some/path/my_import"},
+		{name: "s3", path: "s3://bucket/some/path/my_import"},
+		{name: "azblob", path: "azblob://bucket/some/path/my_import"},
+		{name: "unsupported scheme", path: "ftp://bucket/some/path/my_import", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseImportPath(tc.path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseImportPath(%q) error = %v, wantErr %v", tc.path, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestImportPathDirectories(t *testing.T) {
+	p, err := ParseImportPath("gs://bucket/some/path/my_import")
+	if err != nil {
+		t.Fatalf("ParseImportPath() = %v, want nil error", err)
+	}
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "ImportName", got: p.ImportName(), want: "my_import"},
+		{name: "ConfigPath", got: p.ConfigPath(), want: "gs://bucket/some/path/my_import/config/config.textproto"},
+		{name: "DataDirectory", got: p.DataDirectory(), want: "gs://bucket/some/path/my_import/tmcf_csv"},
+		{name: "CacheDirectory", got: p.CacheDirectory(), want: "gs://bucket/some/path/my_import/cache"},
+		{name: "ControlDirectory", got: p.ControlDirectory(), want: "gs://bucket/some/path/my_import/control"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Errorf("%s = %q, want %q", tc.name, tc.got, tc.want)
+			}
+		})
+	}
+}
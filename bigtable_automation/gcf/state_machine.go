@@ -0,0 +1,176 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf/telemetry"
+)
+
+// ImportState is one state of the import pipeline tracked by
+// ImportStateMachine. Unlike the old init.txt/launched.txt/completed.txt GCS
+// markers, transitions between these states are recorded in a StateStore so
+// a redelivered trigger can Resume mid-flight instead of racing or
+// duplicating work.
+type ImportState string
+
+const (
+	StateInit             ImportState = "INIT"
+	StateTableCreated     ImportState = "TABLE_CREATED"
+	StateDataflowLaunched ImportState = "DATAFLOW_LAUNCHED"
+	StateCacheBuilt       ImportState = "CACHE_BUILT"
+	StateFailed           ImportState = "FAILED"
+)
+
+// ImportRecord is the durable record of an import's progress through the
+// pipeline, keyed by import name in a StateStore.
+type ImportRecord struct {
+	State      ImportState
+	Attempt    int
+	LastError  string
+	StartedAt  time.Time
+	Generation int64
+}
+
+// compensatingAction rolls back the side effect of having entered `state`
+// for the import named importName.
+type compensatingAction func(ctx context.Context, importName string, rec ImportRecord) error
+
+// compensations maps a state to the action that undoes it. Entries are
+// registered at init time (see custom_instance.go); see RegisterCompensation
+// for tests that need to substitute fakes.
+var compensations = map[ImportState]compensatingAction{}
+
+// RegisterCompensation records the rollback action that Rollback runs for
+// state, once state has been entered and a later step fails. Intended to be
+// called from init() by the code that owns the side effect (setupBT,
+// launchDataflowJob, ...).
+func RegisterCompensation(state ImportState, action compensatingAction) {
+	compensations[state] = action
+}
+
+// ImportStateMachine drives a single import through StateInit ->
+// StateTableCreated -> StateDataflowLaunched -> StateCacheBuilt, persisting
+// every transition to a StateStore so that a redelivered Pub/Sub event or a
+// manually re-fired GCS trigger can Resume instead of starting over.
+type ImportStateMachine struct {
+	store      StateStore
+	importName string
+	record     ImportRecord
+}
+
+// Resume loads (or initializes) the ImportStateMachine for importName from
+// the configured StateStore, so callers can pick up mid-flight without
+// duplicating Bigtable table creation or leaking Dataflow jobs.
+func Resume(ctx context.Context, importName string) (*ImportStateMachine, error) {
+	store, err := NewStateStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := store.Get(ctx, importName)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "Failed to load state for import %s", importName)
+	}
+	if rec.State == "" {
+		rec = ImportRecord{State: StateInit, StartedAt: time.Now(), Generation: 1}
+	}
+	return &ImportStateMachine{store: store, importName: importName, record: rec}, nil
+}
+
+// State returns the current state of the import.
+func (m *ImportStateMachine) State() ImportState {
+	return m.record.State
+}
+
+// Transition moves the import to next and persists it. It is idempotent: if
+// the machine is already at next (a redelivered trigger), it returns nil
+// without bumping attempt or generation. On success it clears LastError;
+// transitioning to StateFailed increments Attempt and records err.
+//
+// Save reports back the record actually left in the store, which can differ
+// from the one Transition asked it to write if a concurrent or redelivered
+// invocation already advanced past it. When that happens, Transition adopts
+// the persisted record instead of the local one: if it already reflects
+// next, the race landed the same transition and this call is a no-op; if it
+// reflects some other state, next was never durably written, and Transition
+// returns an error so the caller (e.g. handleBTCache) does not go on to take
+// further side effects as if it had been.
+func (m *ImportStateMachine) Transition(ctx context.Context, next ImportState, transitionErr error) error {
+	logger := telemetry.WithSpan(ctx, "importName", m.importName)
+	if m.record.State == next {
+		logger.Info("Already at state, skipping duplicate transition", "state", next)
+		return nil
+	}
+	rec := m.record
+	rec.State = next
+	rec.Generation++
+	if next == StateFailed {
+		rec.Attempt++
+		if transitionErr != nil {
+			rec.LastError = transitionErr.Error()
+		}
+	} else {
+		rec.LastError = ""
+	}
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("state transition", trace.WithAttributes(
+		attribute.String("state", string(next)),
+		attribute.Int("attempt", rec.Attempt),
+		attribute.Int64("generation", rec.Generation),
+	))
+	persisted, err := m.store.Save(ctx, m.importName, rec)
+	if err != nil {
+		err = errors.WithMessagef(err, "Failed to persist state %s for import %s", next, m.importName)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if persisted.Generation != rec.Generation {
+		m.record = persisted
+		if persisted.State == next {
+			logger.Info("Stale transition matched a racing write to the same state", "state", next, "generation", persisted.Generation)
+			return nil
+		}
+		err := errors.Errorf("Stale transition to %s for import %s: store already advanced to %s (generation %d)", next, m.importName, persisted.State, persisted.Generation)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	m.record = rec
+	logger.Info("State transition", "state", next, "attempt", rec.Attempt, "generation", rec.Generation)
+	return nil
+}
+
+// Rollback runs the compensating action registered for state, if any. It is
+// called when a step after entering state fails so that partial side effects
+// (e.g. a created Bigtable table) don't leak. It runs only when called; a
+// function killed mid-execution runs no compensating action.
+func (m *ImportStateMachine) Rollback(ctx context.Context, state ImportState) error {
+	action, ok := compensations[state]
+	if !ok {
+		return nil
+	}
+	if err := action(ctx, m.importName, m.record); err != nil {
+		return errors.WithMessagef(err, "Compensating action for state %s failed", state)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Supported values for the stateStoreBackend env var.
+const (
+	stateStoreFirestore = "firestore"
+	stateStoreBigtable  = "bigtable"
+
+	importStateCollection = "import-state"
+	importStateFamily     = "state"
+)
+
+// StateStore persists an ImportRecord per import name, transactionally, so
+// concurrent or redelivered triggers for the same import observe a
+// consistent Generation instead of racing on GCS marker files.
+type StateStore interface {
+	// Get returns the current ImportRecord for importName, or a zero-value
+	// ImportRecord (State == "") if none has been recorded yet.
+	Get(ctx context.Context, importName string) (ImportRecord, error)
+	// Save persists rec as the current record for importName, unless a
+	// concurrent or redelivered writer already persisted a generation >=
+	// rec.Generation, in which case the write is skipped. Either way, Save
+	// returns the record actually left in the store (rec on a successful
+	// write, or the existing, newer record if the write was skipped), so
+	// callers can tell a stale write from an applied one instead of trusting
+	// rec blindly.
+	Save(ctx context.Context, importName string, rec ImportRecord) (ImportRecord, error)
+}
+
+// NewStateStore returns the StateStore selected by the stateStoreBackend env
+// var, defaulting to Firestore.
+func NewStateStore(ctx context.Context) (StateStore, error) {
+	switch backend := os.Getenv("stateStoreBackend"); backend {
+	case "", stateStoreFirestore:
+		return newFirestoreStateStore(ctx)
+	case stateStoreBigtable:
+		return newBigtableStateStore(ctx)
+	default:
+		return nil, errors.Errorf("Unsupported stateStoreBackend: %s", backend)
+	}
+}
+
+// firestoreStateStore persists ImportRecords as documents in the
+// import-state collection, one per import name.
+type firestoreStateStore struct {
+	client *firestore.Client
+}
+
+func newFirestoreStateStore(ctx context.Context) (*firestoreStateStore, error) {
+	projectID := os.Getenv("projectID")
+	if projectID == "" {
+		return nil, errors.New("projectID is not set in environment")
+	}
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create firestore client")
+	}
+	return &firestoreStateStore{client: client}, nil
+}
+
+func (s *firestoreStateStore) Get(ctx context.Context, importName string) (ImportRecord, error) {
+	doc, err := s.client.Collection(importStateCollection).Doc(importName).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ImportRecord{}, nil
+	}
+	if err != nil {
+		return ImportRecord{}, errors.Wrap(err, "Failed to read import state")
+	}
+	var rec ImportRecord
+	if err := doc.DataTo(&rec); err != nil {
+		return ImportRecord{}, errors.Wrap(err, "Failed to decode import state")
+	}
+	return rec, nil
+}
+
+func (s *firestoreStateStore) Save(ctx context.Context, importName string, rec ImportRecord) (ImportRecord, error) {
+	persisted := rec
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		ref := s.client.Collection(importStateCollection).Doc(importName)
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return errors.Wrap(err, "Failed to read import state")
+		}
+		if doc != nil && doc.Exists() {
+			var current ImportRecord
+			if err := doc.DataTo(&current); err != nil {
+				return errors.Wrap(err, "Failed to decode import state")
+			}
+			if current.Generation >= rec.Generation {
+				// A newer (or equal) generation already won; this write is
+				// stale, report the record that's actually persisted.
+				persisted = current
+				return nil
+			}
+		}
+		return tx.Set(ref, rec)
+	})
+	if err != nil {
+		return ImportRecord{}, err
+	}
+	return persisted, nil
+}
+
+// bigtableStateStore persists ImportRecords as rows in a dedicated Bigtable
+// metadata table, for deployments that would rather not take a dependency
+// on Firestore.
+type bigtableStateStore struct {
+	client *bigtable.Client
+	table  *bigtable.Table
+}
+
+func newBigtableStateStore(ctx context.Context) (*bigtableStateStore, error) {
+	projectID := os.Getenv("projectID")
+	instance := os.Getenv("instance")
+	metadataTable := os.Getenv("stateStoreTable")
+	if projectID == "" {
+		return nil, errors.New("projectID is not set in environment")
+	}
+	if instance == "" {
+		return nil, errors.New("instance is not set in environment")
+	}
+	if metadataTable == "" {
+		return nil, errors.New("stateStoreTable is not set in environment")
+	}
+	client, err := bigtable.NewClient(ctx, projectID, instance)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create bigtable client")
+	}
+	return &bigtableStateStore{client: client, table: client.Open(metadataTable)}, nil
+}
+
+func (s *bigtableStateStore) Get(ctx context.Context, importName string) (ImportRecord, error) {
+	// Each Save adds a new cell version per column; read only the newest so
+	// the loop below sees the current record rather than an older one.
+	row, err := s.table.ReadRow(ctx, importName, bigtable.RowFilter(bigtable.ChainFilters(
+		bigtable.FamilyFilter(importStateFamily),
+		bigtable.LatestNFilter(1),
+	)))
+	if err != nil {
+		return ImportRecord{}, errors.Wrap(err, "Failed to read import state row")
+	}
+	if row == nil {
+		return ImportRecord{}, nil
+	}
+	var rec ImportRecord
+	for _, col := range row[importStateFamily] {
+		val := string(col.Value)
+		switch col.Column {
+		case importStateFamily + ":state":
+			rec.State = ImportState(val)
+		case importStateFamily + ":attempt":
+			rec.Attempt, _ = strconv.Atoi(val)
+		case importStateFamily + ":lastError":
+			rec.LastError = val
+		case importStateFamily + ":startedAt":
+			sec, _ := strconv.ParseInt(val, 10, 64)
+			rec.StartedAt = time.Unix(sec, 0)
+		case importStateFamily + ":generation":
+			rec.Generation, _ = strconv.ParseInt(val, 10, 64)
+		}
+	}
+	return rec, nil
+}
+
+// encodeGeneration zero-pads generation so that the byte-lexicographic order
+// ValueRangeFilter compares against matches numeric order.
+func encodeGeneration(generation int64) string {
+	return fmt.Sprintf("%020d", generation)
+}
+
+func (s *bigtableStateStore) Save(ctx context.Context, importName string, rec ImportRecord) (ImportRecord, error) {
+	writeMut := bigtable.NewMutation()
+	now := bigtable.Now()
+	writeMut.Set(importStateFamily, "state", now, []byte(rec.State))
+	writeMut.Set(importStateFamily, "attempt", now, []byte(strconv.Itoa(rec.Attempt)))
+	writeMut.Set(importStateFamily, "lastError", now, []byte(rec.LastError))
+	writeMut.Set(importStateFamily, "startedAt", now, []byte(strconv.FormatInt(rec.StartedAt.Unix(), 10)))
+	writeMut.Set(importStateFamily, "generation", now, []byte(encodeGeneration(rec.Generation)))
+
+	// staleFilter matches an existing row whose generation column is already
+	// >= rec.Generation, i.e. a concurrent or redelivered writer already won.
+	// When it matches we must not apply writeMut (condMut's true branch is
+	// nil); when it doesn't match - no row, or an older generation - we
+	// apply writeMut as the false branch.
+	staleFilter := bigtable.ChainFilters(
+		bigtable.FamilyFilter(importStateFamily),
+		bigtable.ColumnFilter("generation"),
+		bigtable.ValueRangeFilter([]byte(encodeGeneration(rec.Generation)), nil),
+	)
+	var stale bool
+	condMut := bigtable.NewCondMutation(staleFilter, nil, writeMut)
+	if err := s.table.Apply(ctx, importName, condMut, bigtable.GetCondMutationResult(&stale)); err != nil {
+		return ImportRecord{}, errors.Wrap(err, "Failed to write import state row")
+	}
+	if stale {
+		current, err := s.Get(ctx, importName)
+		if err != nil {
+			return ImportRecord{}, err
+		}
+		return current, nil
+	}
+	return rec, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcf
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var errEmptyRecords = errors.New("S3 event carried no Records")
+
+// parseEventGridSubject extracts the container and blob name from an Event
+// Grid blob subject of the form
+// "/blobServices/default/containers/<container>/blobs/<blob>".
+func parseEventGridSubject(subject string) (string, string, error) {
+	const containersMarker, blobsMarker = "/containers/", "/blobs/"
+	ci := strings.Index(subject, containersMarker)
+	bi := strings.Index(subject, blobsMarker)
+	if ci == -1 || bi == -1 || bi < ci {
+		return "", "", errors.Errorf("Unexpected Event Grid subject: %s", subject)
+	}
+	container := subject[ci+len(containersMarker) : bi]
+	blob := subject[bi+len(blobsMarker):]
+	return container, blob, nil
+}
+
+// StorageEvent is the backend-agnostic shape that customInternal operates
+// on, regardless of which cloud delivered the original notification.
+type StorageEvent struct {
+	Name   string // File name in the control folder.
+	Bucket string
+}
+
+// GCSEvent is the payload of a GCS object finalize event.
+type GCSEvent struct {
+	Name   string `json:"name"` // File name in the control folder
+	Bucket string `json:"bucket"`
+}
+
+// ToStorageEvent converts a GCSEvent into the backend-agnostic StorageEvent.
+func (e GCSEvent) ToStorageEvent() StorageEvent {
+	return StorageEvent{Name: e.Name, Bucket: e.Bucket}
+}
+
+// S3Event is the subset of an AWS S3 "ObjectCreated" notification
+// (https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html)
+// that customInternal needs.
+type S3Event struct {
+	Records []struct {
+		S3 struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// ToStorageEvent converts the first record of an S3Event into a
+// StorageEvent. S3 delivers one record per object, so the controller is
+// only ever invoked with a single-record payload.
+func (e S3Event) ToStorageEvent() (StorageEvent, error) {
+	if len(e.Records) == 0 {
+		return StorageEvent{}, errEmptyRecords
+	}
+	r := e.Records[0]
+	return StorageEvent{Name: r.S3.Object.Key, Bucket: r.S3.Bucket.Name}, nil
+}
+
+// EventGridEvent is the subset of an Azure Event Grid "BlobCreated" event
+// (https://learn.microsoft.com/en-us/azure/event-grid/event-schema-blob-storage)
+// that customInternal needs.
+type EventGridEvent struct {
+	Subject string `json:"subject"` // e.g. "/blobServices/default/containers/<container>/blobs/<blob>"
+	Data    struct {
+		URL string `json:"url"`
+	} `json:"data"`
+}
+
+// ToStorageEvent converts an EventGridEvent into the backend-agnostic
+// StorageEvent, extracting the container and blob name out of subject.
+func (e EventGridEvent) ToStorageEvent() (StorageEvent, error) {
+	container, blob, err := parseEventGridSubject(e.Subject)
+	if err != nil {
+		return StorageEvent{}, err
+	}
+	return StorageEvent{Name: blob, Bucket: container}, nil
+}
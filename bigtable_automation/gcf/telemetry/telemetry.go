@@ -0,0 +1,101 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telemetry wires up OpenTelemetry tracing, exported to Cloud
+// Trace, and a structured (slog) logger for the gcf package. Init is
+// idempotent so it can be called at the top of every cold-start GCF
+// invocation without paying setup cost twice or leaking exporters.
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies this package's spans in Cloud Trace.
+const tracerName = "github.com/datacommonsorg/tools/bigtable_automation/gcf"
+
+var (
+	initOnce sync.Once
+	initErr  error
+	logger   = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+)
+
+// Init configures the global OTel tracer provider from the otelExporter
+// (cloudtrace|none, default cloudtrace) and otelSampleRatio (default 1.0)
+// env vars. Safe to call on every invocation: the underlying setup runs at
+// most once per instance.
+func Init(ctx context.Context, projectID string) error {
+	initOnce.Do(func() { initErr = configure(ctx, projectID) })
+	return initErr
+}
+
+func configure(ctx context.Context, projectID string) error {
+	if os.Getenv("otelExporter") == "none" {
+		return nil
+	}
+	exp, err := texporter.New(texporter.WithProjectID(projectID))
+	if err != nil {
+		return err
+	}
+	ratio := 1.0
+	if v := os.Getenv("otelSampleRatio"); v != "" {
+		if parsed, parseErr := strconv.ParseFloat(v, 64); parseErr == nil {
+			ratio = parsed
+		}
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceNameKey.String("gcf")))
+	if err != nil {
+		return err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(ratio)),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return nil
+}
+
+// Tracer returns the package-wide tracer. Before Init is called, or when
+// otelExporter=none, it's the OTel no-op tracer.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Logger returns the structured (JSON) logger gcf log lines should use so
+// Cloud Logging entries carry importName/tableID/state/attempt/traceID.
+func Logger() *slog.Logger {
+	return logger
+}
+
+// WithSpan returns Logger() enriched with the traceID of ctx's active span
+// (if any) plus attrs, so a log line correlates with its Cloud Trace span.
+func WithSpan(ctx context.Context, attrs ...any) *slog.Logger {
+	l := logger
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		l = l.With("traceID", sc.TraceID().String())
+	}
+	return l.With(attrs...)
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf"
+)
+
+// DataflowCall records one FakeDataflowLauncher.Launch invocation's
+// arguments, so a test can assert it was launched against the expected
+// table and input/control directories.
+type DataflowCall struct {
+	ProjectID, Instance, TableID, DataPath, ControlPath, Template string
+}
+
+// FakeDataflowLauncher is a gcf.DataflowLauncher whose Launch result is
+// controlled by the test, so handleBTCache's success and rollback paths can
+// both be exercised without a real Dataflow job.
+type FakeDataflowLauncher struct {
+	// Err is returned by Launch; leave nil to simulate a successful launch.
+	Err error
+	// Calls records every Launch invocation, in order.
+	Calls []DataflowCall
+}
+
+func (f *FakeDataflowLauncher) Launch(ctx context.Context, projectID, instance, tableID, dataPath, controlPath, template string) error {
+	f.Calls = append(f.Calls, DataflowCall{
+		ProjectID:   projectID,
+		Instance:    instance,
+		TableID:     tableID,
+		DataPath:    dataPath,
+		ControlPath: controlPath,
+		Template:    template,
+	})
+	return f.Err
+}
+
+// UseFakeDataflowLauncher installs launcher as gcf's DataflowLauncher for
+// the duration of the test.
+func UseFakeDataflowLauncher(t *testing.T, launcher *FakeDataflowLauncher) {
+	t.Helper()
+	restore := gcf.SetDataflowLauncher(launcher)
+	t.Cleanup(restore)
+}
@@ -0,0 +1,212 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testing spins up the Bigtable emulator and an in-process GCS fake
+// so gcf's CustomBTImportController can be exercised end-to-end without
+// touching real Google Cloud.
+package testing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/bigtable"
+	"cloud.google.com/go/bigtable/bttest"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"google.golang.org/grpc"
+
+	"github.com/datacommonsorg/tools/bigtable_automation/gcf"
+)
+
+// stateStoreTable is the Bigtable metadata table FakeEnv provisions for the
+// bigtable-backed StateStore, so tests don't need a Firestore emulator.
+const stateStoreTable = "import-state"
+
+// FakeEnv wires a Bigtable emulator and a GCS fake together and points the
+// env vars gcf reads (projectID, bucket, instance, cluster,
+// dataflowTemplate, storageBackend) at them.
+type FakeEnv struct {
+	ProjectID        string
+	Bucket           string
+	Instance         string
+	Cluster          string
+	DataflowTemplate string
+
+	btServer *bttest.Server
+	btConn   *grpc.ClientConn
+	gcsFake  *fakestorage.Server
+}
+
+// NewFakeEnv starts the emulators, points the gcf env vars at them, and
+// registers cleanup via t.Cleanup.
+func NewFakeEnv(t *testing.T) *FakeEnv {
+	t.Helper()
+
+	btServer, err := bttest.NewServer("localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to start bigtable emulator: %v", err)
+	}
+	t.Cleanup(btServer.Close)
+
+	conn, err := grpc.Dial(btServer.Addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial bigtable emulator: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	env := &FakeEnv{
+		ProjectID:        "fake-project",
+		Bucket:           "fake-bucket",
+		Instance:         "fake-instance",
+		Cluster:          "fake-cluster",
+		DataflowTemplate: "fake-template",
+		btServer:         btServer,
+		btConn:           conn,
+	}
+
+	gcsFake := fakestorage.NewServer(nil)
+	t.Cleanup(gcsFake.Stop)
+	env.gcsFake = gcsFake
+	os.Setenv("STORAGE_EMULATOR_HOST", gcsFake.URL())
+	gcsFake.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: env.Bucket})
+
+	os.Setenv("projectID", env.ProjectID)
+	os.Setenv("bucket", env.Bucket)
+	os.Setenv("instance", env.Instance)
+	os.Setenv("cluster", env.Cluster)
+	os.Setenv("dataflowTemplate", env.DataflowTemplate)
+	os.Setenv("storageBackend", "gcs")
+	os.Setenv("stateStoreBackend", "bigtable")
+	os.Setenv("stateStoreTable", stateStoreTable)
+	os.Setenv("BIGTABLE_EMULATOR_HOST", fakeEmulatorHost(t, btServer.Addr))
+
+	env.createStateStoreTable(t)
+
+	return env
+}
+
+func (e *FakeEnv) createStateStoreTable(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	adminClient, err := bigtable.NewAdminClient(ctx, e.ProjectID, e.Instance)
+	if err != nil {
+		t.Fatalf("Failed to create admin client: %v", err)
+	}
+	defer adminClient.Close()
+	if err := adminClient.CreateTable(ctx, stateStoreTable); err != nil {
+		t.Fatalf("Failed to create state store table: %v", err)
+	}
+	if err := adminClient.CreateColumnFamily(ctx, stateStoreTable, "state"); err != nil {
+		t.Fatalf("Failed to create state store column family: %v", err)
+	}
+}
+
+// AssertImportState fails the test if the persisted state for tableID does
+// not match want.
+func (e *FakeEnv) AssertImportState(t *testing.T, tableID string, want gcf.ImportState) {
+	t.Helper()
+	sm, err := gcf.Resume(context.Background(), tableID)
+	if err != nil {
+		t.Fatalf("Failed to resume state for %s: %v", tableID, err)
+	}
+	if got := sm.State(); got != want {
+		t.Errorf("Import %s: got state %s, want %s", tableID, got, want)
+	}
+}
+
+// fakeEmulatorHost checks that addr (as returned by bttest.Server) is in the
+// host:port form the Bigtable client libraries expect in
+// BIGTABLE_EMULATOR_HOST, and returns it unchanged.
+func fakeEmulatorHost(t *testing.T, addr string) string {
+	t.Helper()
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		t.Fatalf("Unexpected bigtable emulator address %q: %v", addr, err)
+	}
+	return addr
+}
+
+// SeedControlFile writes an empty object at
+// gs://<bucket>/some/path/<tableID>/control/<tableID>/<name>, mirroring the marker
+// files CustomBTImportController reacts to.
+func (e *FakeEnv) SeedControlFile(t *testing.T, tableID, name string) {
+	t.Helper()
+	e.gcsFake.CreateObject(fakestorage.Object{
+		ObjectAttrs: fakestorage.ObjectAttrs{BucketName: e.Bucket, Name: fmt.Sprintf("some/path/%s/control/%s/%s", tableID, tableID, name)},
+	})
+}
+
+// AssertTableExists fails the test if tableID does not exist in the
+// emulator.
+func (e *FakeEnv) AssertTableExists(t *testing.T, tableID string) {
+	t.Helper()
+	if !e.tableExists(t, tableID) {
+		t.Errorf("Expected bigtable table %s to exist, it does not", tableID)
+	}
+}
+
+// AssertTableNotExists fails the test if tableID exists in the emulator,
+// e.g. to verify handleBTCache rolled back table creation after a failed
+// Dataflow launch.
+func (e *FakeEnv) AssertTableNotExists(t *testing.T, tableID string) {
+	t.Helper()
+	if e.tableExists(t, tableID) {
+		t.Errorf("Expected bigtable table %s to not exist, but it does", tableID)
+	}
+}
+
+func (e *FakeEnv) tableExists(t *testing.T, tableID string) bool {
+	t.Helper()
+	ctx := context.Background()
+	adminClient, err := bigtable.NewAdminClient(ctx, e.ProjectID, e.Instance)
+	if err != nil {
+		t.Fatalf("Failed to create admin client: %v", err)
+	}
+	defer adminClient.Close()
+	tables, err := adminClient.Tables(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list tables: %v", err)
+	}
+	for _, tbl := range tables {
+		if tbl == tableID {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertColumnFamilyExists fails the test if tableID does not have family
+// among its column families, e.g. to verify setupBT created the cache
+// column family alongside the table itself.
+func (e *FakeEnv) AssertColumnFamilyExists(t *testing.T, tableID, family string) {
+	t.Helper()
+	ctx := context.Background()
+	adminClient, err := bigtable.NewAdminClient(ctx, e.ProjectID, e.Instance)
+	if err != nil {
+		t.Fatalf("Failed to create admin client: %v", err)
+	}
+	defer adminClient.Close()
+	info, err := adminClient.TableInfo(ctx, tableID)
+	if err != nil {
+		t.Fatalf("Failed to read table info for %s: %v", tableID, err)
+	}
+	for _, fam := range info.FamilyInfos {
+		if fam.Name == family {
+			return
+		}
+	}
+	t.Errorf("Expected table %s to have column family %s, it does not", tableID, family)
+}